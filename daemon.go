@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+)
+
+// daemonRequest is one line of the daemon's line-delimited JSON protocol.
+// Name is LAST_MARKED_KEY for the unnamed "last marked" slot. File is the
+// marks file the *client* resolved via get_hpoon_file() (honoring its own
+// cwd and --global/--local) - the daemon operates on that file rather than
+// re-resolving scope from its own cwd, so a project-scoped client can't
+// end up reading/writing another project's (or the global) marks file
+// just because a long-running daemon happens to have been started
+// elsewhere.
+type daemonRequest struct {
+	Cmd  string `json:"cmd"`
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+	Line int    `json:"line,omitempty"`
+	File string `json:"file"`
+	// Slot is the target slot for "reorder".
+	Slot int `json:"slot,omitempty"`
+	// Days is the max age in days for "expire".
+	Days int `json:"days,omitempty"`
+}
+
+type daemonResponse struct {
+	OK    bool       `json:"ok"`
+	Error string     `json:"error,omitempty"`
+	Mark  *jsonMark  `json:"mark,omitempty"`
+	Marks []jsonMark `json:"marks,omitempty"`
+	// Event carries a change notification pushed to "subscribe" clients;
+	// unused on request/response pairs.
+	Event string `json:"event,omitempty"`
+}
+
+func get_daemon_socket() string {
+	return os.Getenv("HPOON_SOCKET")
+}
+
+func daemon_available() bool {
+	return get_daemon_socket() != ""
+}
+
+// daemon_request sends one request and reads one response line. Any error
+// (including "no daemon listening") is the caller's cue to fall back to
+// direct file access.
+func daemon_request(req daemonRequest) (daemonResponse, error) {
+	conn, dial_err := net.Dial("unix", get_daemon_socket())
+	if dial_err != nil {
+		return daemonResponse{}, dial_err
+	}
+	defer conn.Close()
+
+	if encode_err := json.NewEncoder(conn).Encode(req); encode_err != nil {
+		return daemonResponse{}, encode_err
+	}
+
+	var resp daemonResponse
+	if decode_err := json.NewDecoder(conn).Decode(&resp); decode_err != nil {
+		return daemonResponse{}, decode_err
+	}
+	return resp, nil
+}
+
+func mark_entry_to_json(slot int, mark MarkEntry) jsonMark {
+	return jsonMark{
+		Slot:      slot,
+		Name:      mark.name,
+		Path:      mark.path,
+		Kind:      mark.kind,
+		Line:      mark.line,
+		CreatedAt: mark.created_at,
+		Source:    mark.source,
+	}
+}
+
+func try_daemon_set_mark(fpath string, name *string, line int) bool {
+	req := daemonRequest{Cmd: "set", Name: LAST_MARKED_KEY, Path: fpath, Line: line, File: get_hpoon_file()}
+	if name != nil {
+		req.Name = *name
+	}
+	resp, req_err := daemon_request(req)
+	return req_err == nil && resp.OK
+}
+
+func try_daemon_get_mark(name *string) (*MarkEntry, bool) {
+	req := daemonRequest{Cmd: "get", Name: LAST_MARKED_KEY, File: get_hpoon_file()}
+	if name != nil {
+		req.Name = *name
+	}
+	resp, req_err := daemon_request(req)
+	if req_err != nil || !resp.OK || resp.Mark == nil {
+		return nil, false
+	}
+	entry := MarkEntry{
+		name:       resp.Mark.Name,
+		path:       resp.Mark.Path,
+		kind:       resp.Mark.Kind,
+		line:       resp.Mark.Line,
+		created_at: resp.Mark.CreatedAt,
+		source:     resp.Mark.Source,
+	}
+	return &entry, true
+}
+
+func try_daemon_list() ([]MarkEntry, bool) {
+	resp, req_err := daemon_request(daemonRequest{Cmd: "list", File: get_hpoon_file()})
+	if req_err != nil || !resp.OK {
+		return nil, false
+	}
+	marks := make([]MarkEntry, 0, len(resp.Marks))
+	for _, m := range resp.Marks {
+		marks = append(marks, MarkEntry{
+			name: m.Name, path: m.Path, kind: m.Kind,
+			line: m.Line, created_at: m.CreatedAt, source: m.Source,
+		})
+	}
+	return marks, true
+}
+
+func try_daemon_delete(name string) bool {
+	resp, req_err := daemon_request(daemonRequest{Cmd: "delete", Name: name, File: get_hpoon_file()})
+	return req_err == nil && resp.OK
+}
+
+func try_daemon_reorder(name string, slot int) bool {
+	resp, req_err := daemon_request(daemonRequest{Cmd: "reorder", Name: name, Slot: slot, File: get_hpoon_file()})
+	return req_err == nil && resp.OK
+}
+
+func try_daemon_expire(max_age_days int) bool {
+	resp, req_err := daemon_request(daemonRequest{Cmd: "expire", Days: max_age_days, File: get_hpoon_file()})
+	return req_err == nil && resp.OK
+}
+
+func try_daemon_clean() bool {
+	resp, req_err := daemon_request(daemonRequest{Cmd: "clean", File: get_hpoon_file()})
+	return req_err == nil && resp.OK
+}
+
+// subscriberHub lets "subscribe" connections learn about mark changes made
+// by any other connection, instead of re-reading the marks file.
+type subscriberHub struct {
+	mu   sync.Mutex
+	subs map[chan string]struct{}
+}
+
+func new_subscriber_hub() *subscriberHub {
+	return &subscriberHub{subs: make(map[chan string]struct{})}
+}
+
+func (h *subscriberHub) subscribe() chan string {
+	ch := make(chan string, 16)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *subscriberHub) unsubscribe(ch chan string) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	close(ch)
+	h.mu.Unlock()
+}
+
+func (h *subscriberHub) broadcast(event string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+			// subscriber isn't draining fast enough; drop rather than
+			// block the connection that triggered the change
+		}
+	}
+}
+
+// hpoon_daemon listens on HPOON_SOCKET (or the default daemon socket path)
+// and serves get/set/list/delete/reorder/expire/clean/subscribe so
+// editor/shell integrations can react to mark changes without forking the
+// CLI per call.
+func hpoon_daemon() {
+	socket_path := get_daemon_socket()
+	if socket_path == "" {
+		socket_path = get_hpoon_file() + ".sock"
+	}
+	os.Remove(socket_path) // drop a stale socket left by a prior crash
+
+	listener, listen_err := net.Listen("unix", socket_path)
+	if listen_err != nil {
+		quit("Error starting hpoon daemon: %s", listen_err.Error())
+	}
+	defer listener.Close()
+
+	hub := new_subscriber_hub()
+	fmt.Printf("hpoon daemon listening on %s\n", socket_path)
+
+	for {
+		conn, accept_err := listener.Accept()
+		if accept_err != nil {
+			report("Error accepting daemon connection", accept_err.Error())
+			continue
+		}
+		go handle_daemon_conn(conn, hub)
+	}
+}
+
+func handle_daemon_conn(conn net.Conn, hub *subscriberHub) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		var req daemonRequest
+		if decode_err := json.Unmarshal(scanner.Bytes(), &req); decode_err != nil {
+			encoder.Encode(daemonResponse{OK: false, Error: decode_err.Error()})
+			continue
+		}
+
+		if req.Cmd != "subscribe" && req.File == "" {
+			encoder.Encode(daemonResponse{OK: false, Error: "request carries no marks file scope"})
+			continue
+		}
+
+		switch req.Cmd {
+		case "get":
+			handle_daemon_get(encoder, req)
+		case "set":
+			handle_daemon_set(encoder, req, hub)
+		case "list":
+			handle_daemon_list(encoder, req)
+		case "delete":
+			handle_daemon_delete(encoder, req, hub)
+		case "reorder":
+			handle_daemon_reorder(encoder, req, hub)
+		case "expire":
+			handle_daemon_expire(encoder, req, hub)
+		case "clean":
+			handle_daemon_clean(encoder, req, hub)
+		case "subscribe":
+			handle_daemon_subscribe(conn, encoder, hub)
+			return
+		default:
+			encoder.Encode(daemonResponse{OK: false, Error: fmt.Sprintf("unknown cmd '%s'", req.Cmd)})
+		}
+	}
+}
+
+// handle_daemon_get/set/list/delete all operate on req.File, the marks file
+// the client resolved for its own scope (see daemonRequest), and go through
+// the *_at functions rather than load_hpoon/update_hpoon/hpoon_*_local so a
+// lock or I/O failure reports back on this connection instead of calling
+// quit and taking the whole daemon down with it.
+
+func handle_daemon_get(encoder *json.Encoder, req daemonRequest) {
+	var name *string
+	if req.Name != LAST_MARKED_KEY {
+		name = &req.Name
+	}
+
+	mark, get_err := hpoon_get_mark_at(req.File, name)
+	if get_err != nil {
+		encoder.Encode(daemonResponse{OK: false, Error: get_err.Error()})
+		return
+	}
+	json_mark := mark_entry_to_json(0, *mark)
+	encoder.Encode(daemonResponse{OK: true, Mark: &json_mark})
+}
+
+func handle_daemon_set(encoder *json.Encoder, req daemonRequest, hub *subscriberHub) {
+	var name *string
+	if req.Name != LAST_MARKED_KEY {
+		name = &req.Name
+	}
+
+	if set_err := hpoon_set_mark_at(req.File, req.Path, name, req.Line); set_err != nil {
+		encoder.Encode(daemonResponse{OK: false, Error: set_err.Error()})
+		return
+	}
+	hub.broadcast("set:" + req.Name)
+	encoder.Encode(daemonResponse{OK: true})
+}
+
+func handle_daemon_list(encoder *json.Encoder, req daemonRequest) {
+	data, load_err := load_hpoon_at(req.File)
+	if load_err != nil {
+		encoder.Encode(daemonResponse{OK: false, Error: load_err.Error()})
+		return
+	}
+	encoder.Encode(daemonResponse{OK: true, Marks: to_json_marks(data.marks)})
+}
+
+func handle_daemon_delete(encoder *json.Encoder, req daemonRequest, hub *subscriberHub) {
+	if delete_err := hpoon_delete_at(req.File, req.Name); delete_err != nil {
+		encoder.Encode(daemonResponse{OK: false, Error: delete_err.Error()})
+		return
+	}
+	hub.broadcast("delete:" + req.Name)
+	encoder.Encode(daemonResponse{OK: true})
+}
+
+func handle_daemon_reorder(encoder *json.Encoder, req daemonRequest, hub *subscriberHub) {
+	if reorder_err := hpoon_reorder_at(req.File, req.Name, req.Slot); reorder_err != nil {
+		encoder.Encode(daemonResponse{OK: false, Error: reorder_err.Error()})
+		return
+	}
+	hub.broadcast("reorder:" + req.Name)
+	encoder.Encode(daemonResponse{OK: true})
+}
+
+func handle_daemon_expire(encoder *json.Encoder, req daemonRequest, hub *subscriberHub) {
+	if expire_err := hpoon_expire_at(req.File, req.Days); expire_err != nil {
+		encoder.Encode(daemonResponse{OK: false, Error: expire_err.Error()})
+		return
+	}
+	hub.broadcast("expire")
+	encoder.Encode(daemonResponse{OK: true})
+}
+
+func handle_daemon_clean(encoder *json.Encoder, req daemonRequest, hub *subscriberHub) {
+	os.Remove(req.File)
+	hub.broadcast("clean")
+	encoder.Encode(daemonResponse{OK: true})
+}
+
+func handle_daemon_subscribe(conn net.Conn, encoder *json.Encoder, hub *subscriberHub) {
+	ch := hub.subscribe()
+	defer hub.unsubscribe(ch)
+
+	for event := range ch {
+		if encode_err := encoder.Encode(daemonResponse{OK: true, Event: event}); encode_err != nil {
+			return
+		}
+	}
+}
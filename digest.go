@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// compute_file_digest hashes a file's contents so a moved or replaced mark
+// target can be told apart from one that's merely been renamed in place.
+func compute_file_digest(path string) (string, error) {
+	file, open_err := os.Open(path)
+	if open_err != nil {
+		return "", open_err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, copy_err := io.Copy(hasher, file); copy_err != nil {
+		return "", copy_err
+	}
+
+	return "sha256:" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// compute_mark_digest picks the right identity check for the mark's kind:
+// a content hash for files, an inode+mtime tuple for directories (hashing
+// a whole directory tree isn't worth the cost here).
+func compute_mark_digest(path string, kind string) (string, error) {
+	if kind == KIND_DIR {
+		return compute_dir_digest(path)
+	}
+	return compute_file_digest(path)
+}
+
+// get_search_root reads the configurable root that `hpoon !name` walks to
+// relocate a mark whose target has moved. Unset means the feature is off.
+func get_search_root() (string, bool) {
+	root := os.Getenv("HPOON_SEARCH_ROOT")
+	return root, root != ""
+}
+
+// locate_by_digest walks root for an entry of the given kind whose content
+// digest matches, stopping at the first match.
+func locate_by_digest(root string, digest string, kind string) (string, bool) {
+	found := ""
+
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, walk_err error) error {
+		if walk_err != nil {
+			return nil // skip unreadable entries rather than aborting the whole walk
+		}
+		if found != "" {
+			return filepath.SkipAll
+		}
+
+		wants_dir := kind == KIND_DIR
+		if d.IsDir() != wants_dir {
+			return nil
+		}
+
+		candidate_digest, digest_err := compute_mark_digest(path, kind)
+		if digest_err != nil {
+			return nil
+		}
+		if candidate_digest == digest {
+			found = path
+			return filepath.SkipAll
+		}
+		return nil
+	})
+
+	return found, found != ""
+}
+
+// try_relocate_mark looks for entry's target under HPOON_SEARCH_ROOT by
+// content digest, for use when the recorded path no longer exists.
+func try_relocate_mark(entry MarkEntry) (MarkEntry, bool) {
+	if entry.digest == "" {
+		return entry, false
+	}
+	root, configured := get_search_root()
+	if !configured {
+		return entry, false
+	}
+	found_path, found := locate_by_digest(root, entry.digest, entry.kind)
+	if !found {
+		return entry, false
+	}
+	entry.path = found_path
+	return entry, true
+}
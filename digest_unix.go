@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// compute_dir_digest identifies a directory by inode and mtime, so a
+// directory that's been recreated (new inode) or touched (new mtime) is
+// detected even though we don't hash its contents.
+func compute_dir_digest(path string) (string, error) {
+	info, stat_err := os.Stat(path)
+	if stat_err != nil {
+		return "", stat_err
+	}
+
+	sys, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", err("cannot determine inode for '%s'", path)
+	}
+
+	return fmt.Sprintf("inode:%d:%d", sys.Ino, info.ModTime().Unix()), nil
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// compute_dir_digest identifies a directory by size and mtime. Windows
+// doesn't expose an inode through os.FileInfo, so this is weaker than the
+// Unix inode+mtime tuple: a directory rewritten in place with the same
+// mtime would not be detected.
+func compute_dir_digest(path string) (string, error) {
+	info, stat_err := os.Stat(path)
+	if stat_err != nil {
+		return "", stat_err
+	}
+
+	return fmt.Sprintf("inode:%d:%d", info.Size(), info.ModTime().Unix()), nil
+}
@@ -0,0 +1,14 @@
+package main
+
+// hpoon_lock is an advisory lock on a marks file, held around a
+// read-modify-write cycle so two shells racing to mark can't corrupt or
+// lose entries. Acquiring and releasing one is platform-specific (see
+// lock_unix.go / lock_windows.go), so this type just wraps whatever
+// teardown the platform implementation needs.
+type hpoon_lock struct {
+	release_fn func()
+}
+
+func (l *hpoon_lock) release() {
+	l.release_fn()
+}
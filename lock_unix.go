@@ -0,0 +1,29 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+func acquire_hpoon_lock(filename string, exclusive bool) (*hpoon_lock, error) {
+	file, open_err := os.OpenFile(filename+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if open_err != nil {
+		return nil, open_err
+	}
+
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if lock_err := syscall.Flock(int(file.Fd()), how); lock_err != nil {
+		file.Close()
+		return nil, lock_err
+	}
+
+	return &hpoon_lock{release_fn: func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}}, nil
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Windows' stdlib syscall package doesn't expose LockFileEx, so the lock
+// itself is the exclusive creation of filename+".lock": whoever creates it
+// holds the lock, and releasing means removing it. exclusive is ignored
+// (every lock here is exclusive) since there's no cheap shared-lock
+// primitive to back a read lock with.
+func acquire_hpoon_lock(filename string, exclusive bool) (*hpoon_lock, error) {
+	lock_path := filename + ".lock"
+
+	for {
+		file, open_err := os.OpenFile(lock_path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+		if open_err == nil {
+			file.Close()
+			return &hpoon_lock{release_fn: func() {
+				os.Remove(lock_path)
+			}}, nil
+		}
+		if !os.IsExist(open_err) {
+			return nil, open_err
+		}
+		time.Sleep(25 * time.Millisecond)
+	}
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"bufio"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const help_str = `
@@ -17,12 +20,40 @@ Usage:
     hpoon <path> [name]         | store a mark, optionally with a name
     hpoon                       | retrieve the last marked path
     hpoon !<name>               | retrieve marked path with name (mark is recognized by prefix "!")
-    hpoon list                  | list all named marked paths
+    hpoon @<N>                  | retrieve the Nth marked path (1-based slot)
+    hpoon next                  | cycle to the next slot and retrieve its path
+    hpoon prev                  | cycle to the previous slot and retrieve its path
+    hpoon reorder <name> <N>    | move the mark "name" to slot N
+    hpoon <path>:<line> [name]  | store a mark pointing at a specific line
+    hpoon list                  | list all named marked paths, in slot order
+    hpoon --json list           | same, as a JSON array for editor integration
+    hpoon fzf                   | list as "slot<TAB>name<TAB>path", for piping into fzf
+    hpoon expire <days>         | drop marks older than <days>
+    hpoon verify                | report marks whose target has moved, been replaced, or changed
+    hpoon delete <name>         | remove a single named mark
     hpoon clean                 | delete all hpoon history
+    hpoon init                  | mark the current directory as a project root
+    hpoon daemon                | listen on a unix socket, serving
+                                 | get/set/list/delete/reorder/expire/clean/subscribe
+
+    --global                    | force the global marks file, ignoring any project root
+    --local                     | force a project-local marks file, erroring if none is found
+
+    HPOON_SEARCH_ROOT           | if set, a dead mark ("!name") is relocated by
+                                 | walking this directory for a file/dir whose
+                                 | content digest still matches
+    HPOON_SOCKET                | if set, the CLI talks to "hpoon daemon" over this
+                                 | unix socket instead of reading/writing the marks
+                                 | file directly, falling back to the file on error
 
     can only mark files and directories that exist, but can retreive
     marks that no longer exist on the filesystem
 
+    when no scope flag is given, hpoon looks upward from $PWD for a
+    project root (a directory containing ` + "`.hpoon`" + `, created by ` + "`hpoon init`" + `)
+    and uses a marks file scoped to that project, falling back to the
+    global marks file otherwise
+
 Examples:
 
     cd /path/to/dir     # cd to a dir
@@ -45,8 +76,33 @@ const (
 	LAST_MARKED_KEY = "_"
 	KV_SEPERATOR    = "/"
 	NAME_REF        = "!"
+	SLOT_REF        = "@"
+)
+
+const (
+	PROJECT_MARKER     = ".hpoon"
+	PROJECT_MARKS_FILE = ".hpoon.marks"
+)
+
+const (
+	KIND_FILE = "file"
+	KIND_DIR  = "dir"
+	KIND_LINE = "line"
 )
 
+// HPOON_V2_HEADER marks a v2 marks file. Its absence means the file
+// predates v2 and is read with parse_hpoon_line_v1 instead.
+const HPOON_V2_HEADER = "hpoon v2"
+
+const V2_FIELD_SEPERATOR = "\t"
+
+// forced_scope is set from the --global/--local flags before any command
+// runs; "" means auto-detect via find_project_root.
+var forced_scope = ""
+
+// json_output is set from --json before any command runs.
+var json_output = false
+
 func quit(msg string, printargs ...any) {
 	fmt.Printf(msg+"\n", printargs...)
 	os.Exit(1)
@@ -64,12 +120,79 @@ func report(printargs ...string) {
 	fmt.Fprintln(os.Stderr, printargs)
 }
 
+// MarkEntry is a single mark, occupying a slot. Slot number is its 1-based
+// position within HarpoonRecord.marks, so ordering is carried by the slice
+// itself rather than stored per-entry.
+//
+// line is 0 unless kind is KIND_LINE. created_at and source are 0/"" for
+// marks migrated from a v1 file, since that format couldn't record them.
+type MarkEntry struct {
+	name       string
+	path       string
+	kind       string
+	line       int
+	created_at int64
+	source     string
+	// digest is the target's content hash (files) or inode+mtime tuple
+	// (dirs), recorded so `hpoon verify` can detect it moving, being
+	// replaced, or being modified. Empty for marks migrated from a file
+	// format that predates digests.
+	digest string
+}
+
 type HarpoonRecord struct {
-	last_marked string
-	marks       map[string]string
+	last_marked MarkEntry
+	marks       []MarkEntry
 }
 
-func get_hpoon_file() string {
+// get_source_tag identifies the shell a mark was made from, best-effort,
+// for display in `hpoon --json list`.
+func get_source_tag() string {
+	if tty, read_err := os.Readlink("/proc/self/fd/0"); read_err == nil {
+		return tty
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+// make_mark_entry builds a fresh mark for fpath, stamped with the current
+// time and source shell. line is 0 unless the mark targets a specific line.
+func make_mark_entry(name string, fpath string, line int) MarkEntry {
+	kind := KIND_FILE
+	if info, stat_err := os.Stat(fpath); stat_err == nil && info.IsDir() {
+		kind = KIND_DIR
+	}
+	if line > 0 {
+		kind = KIND_LINE
+	}
+
+	digest, digest_err := compute_mark_digest(fpath, kind)
+	if digest_err != nil {
+		report("Failed to hash mark target", digest_err.Error())
+		digest = ""
+	}
+
+	return MarkEntry{
+		name:       name,
+		path:       fpath,
+		kind:       kind,
+		line:       line,
+		created_at: time.Now().Unix(),
+		source:     get_source_tag(),
+		digest:     digest,
+	}
+}
+
+// format_mark_output renders a mark the way retrieval commands print it:
+// "path:line" for a line mark, otherwise just the path.
+func format_mark_output(entry MarkEntry) string {
+	if entry.kind == KIND_LINE && entry.line > 0 {
+		return fmt.Sprintf("%s:%d", entry.path, entry.line)
+	}
+	return entry.path
+}
+
+func get_global_hpoon_file() string {
 	switch runtime.GOOS {
 	case "windows":
 		return "C:\\Windows\\Temp\\hpoon"
@@ -78,7 +201,91 @@ func get_hpoon_file() string {
 	}
 }
 
-func parse_hpoon_line(line string) (string, string, error) {
+// find_project_root walks upward from start looking for a directory
+// containing PROJECT_MARKER, the same way ".git" is discovered, stopping
+// at the filesystem root.
+func find_project_root(start string) (string, bool) {
+	dir := start
+	for {
+		if check_path_exists(filepath.Join(dir, PROJECT_MARKER)) {
+			return dir, true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// test_hpoon_file_override lets tests point hpoon at a scratch file instead
+// of resolving project/global scope, without threading a filename through
+// every call in the package.
+var test_hpoon_file_override = ""
+
+func get_hpoon_file() string {
+	if test_hpoon_file_override != "" {
+		return test_hpoon_file_override
+	}
+
+	cwd, cwd_err := os.Getwd()
+	if cwd_err != nil {
+		quit("Error getting current directory: %s", cwd_err.Error())
+	}
+
+	switch forced_scope {
+	case "global":
+		return get_global_hpoon_file()
+	case "local":
+		root, ok := find_project_root(cwd)
+		if !ok {
+			quit("No project root found (run 'hpoon init'), and --local was given")
+		}
+		return filepath.Join(root, PROJECT_MARKS_FILE)
+	default:
+		if root, ok := find_project_root(cwd); ok {
+			return filepath.Join(root, PROJECT_MARKS_FILE)
+		}
+		return get_global_hpoon_file()
+	}
+}
+
+func hpoon_init() {
+	cwd, cwd_err := os.Getwd()
+	if cwd_err != nil {
+		quit("Error getting current directory: %s", cwd_err.Error())
+	}
+
+	marker := filepath.Join(cwd, PROJECT_MARKER)
+	if check_path_exists(marker) {
+		quit("Already a hpoon project root: '%s'", cwd)
+	}
+
+	file, create_err := os.Create(marker)
+	if create_err != nil {
+		quit("Error creating project marker '%s': %s", marker, create_err.Error())
+	}
+	file.Close()
+}
+
+func get_cursor_file() string {
+	return get_hpoon_file() + ".cursor"
+}
+
+// find_mark returns the slice index of the mark named "name", or false if
+// no such mark exists.
+func find_mark(data HarpoonRecord, name string) (int, bool) {
+	for i, m := range data.marks {
+		if m.name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// parse_hpoon_line_v1 parses the original "key/base64(value)" line format,
+// kept only to read marks files written before v2.
+func parse_hpoon_line_v1(line string) (string, string, error) {
 	parts := strings.Split(line, KV_SEPERATOR)
 	if len(parts) != 2 {
 		return "", "", err("Invalid format of line: %s", line)
@@ -95,89 +302,236 @@ func parse_hpoon_line(line string) (string, string, error) {
 	return key, string(decoded_bytes), nil
 }
 
-func create_hpoon_line(key string, value string) string {
-	encoded_string := base64.StdEncoding.EncodeToString([]byte(value))
+// parse_mark_line_v2 parses one v2 record: base64(name), kind, base64(path),
+// line, created_at and base64(source), tab-separated. Name/path/source are
+// base64-encoded individually (rather than relying on a single separator
+// across the whole line) so none of them can corrupt the field count by
+// containing a tab or a "/" from their own base64 encoding.
+func parse_mark_line_v2(line string) (MarkEntry, error) {
+	parts := strings.Split(line, V2_FIELD_SEPERATOR)
+	if len(parts) != 7 {
+		return MarkEntry{}, err("Invalid v2 record: %s", line)
+	}
+
+	name_bytes, decode_err := base64.StdEncoding.DecodeString(parts[0])
+	if decode_err != nil {
+		return MarkEntry{}, decode_err
+	}
+	path_bytes, decode_err := base64.StdEncoding.DecodeString(parts[2])
+	if decode_err != nil {
+		return MarkEntry{}, decode_err
+	}
+	source_bytes, decode_err := base64.StdEncoding.DecodeString(parts[5])
+	if decode_err != nil {
+		return MarkEntry{}, decode_err
+	}
 
-	return fmt.Sprintf("%s%s%s", key, KV_SEPERATOR, encoded_string)
+	line_num, conv_err := strconv.Atoi(parts[3])
+	if conv_err != nil {
+		return MarkEntry{}, conv_err
+	}
+	created_at, conv_err := strconv.ParseInt(parts[4], 10, 64)
+	if conv_err != nil {
+		return MarkEntry{}, conv_err
+	}
+
+	return MarkEntry{
+		name:       string(name_bytes),
+		kind:       parts[1],
+		path:       string(path_bytes),
+		line:       line_num,
+		created_at: created_at,
+		source:     string(source_bytes),
+		// digest is plain ASCII (hex digest / inode tuple, with a
+		// "sha256:"/"inode:" prefix) so it needs no encoding of its own.
+		digest: parts[6],
+	}, nil
 }
 
-func read_hpoon_file(filename string) HarpoonRecord {
-	file, err := os.Open(filename)
-	if err != nil {
-		quit("Error reading hpoon marks file '%s'", filename)
+func create_mark_line_v2(entry MarkEntry) string {
+	fields := []string{
+		base64.StdEncoding.EncodeToString([]byte(entry.name)),
+		entry.kind,
+		base64.StdEncoding.EncodeToString([]byte(entry.path)),
+		strconv.Itoa(entry.line),
+		strconv.FormatInt(entry.created_at, 10),
+		base64.StdEncoding.EncodeToString([]byte(entry.source)),
+		entry.digest,
+	}
+	return strings.Join(fields, V2_FIELD_SEPERATOR)
+}
+
+// read_hpoon_file returns an error instead of calling quit on any failure,
+// since it runs under load_hpoon_at/update_hpoon_at while the marks file
+// lock is held - quit's os.Exit would skip the deferred release and leave
+// the lock file behind (see lock_windows.go).
+func read_hpoon_file(filename string) (HarpoonRecord, error) {
+	file, open_err := os.Open(filename)
+	if open_err != nil {
+		return HarpoonRecord{}, err("Error reading hpoon marks file '%s'", filename)
 	}
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return HarpoonRecord{marks: make([]MarkEntry, 0)}, nil
+	}
 
-	data := make(map[string]string)
-	last_marked := ""
+	first_line := scanner.Text()
+	if first_line == HPOON_V2_HEADER {
+		return read_hpoon_records_v2(scanner)
+	}
+	return read_hpoon_records_v1(first_line, scanner)
+}
+
+func read_hpoon_records_v2(scanner *bufio.Scanner) (HarpoonRecord, error) {
+	var last_marked MarkEntry
+	marks := make([]MarkEntry, 0)
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		key, val, err := parse_hpoon_line(line)
-		if err != nil {
-			report("Failed to hpoon marks file", err.Error())
+		entry, parse_err := parse_mark_line_v2(scanner.Text())
+		if parse_err != nil {
+			report("Failed to hpoon marks file", parse_err.Error())
 			continue
 		}
+		if entry.name == LAST_MARKED_KEY {
+			last_marked = entry
+		} else {
+			marks = append(marks, entry)
+		}
+	}
+
+	if scan_err := scanner.Err(); scan_err != nil {
+		return HarpoonRecord{}, err("Error parsing file: %s", scan_err.Error())
+	}
+
+	return HarpoonRecord{last_marked: last_marked, marks: marks}, nil
+}
+
+// read_hpoon_records_v1 reads a pre-v2 marks file. Marks picked up this way
+// have no kind/line/created_at/source yet (zero values); the next write
+// auto-migrates the whole file to v2.
+func read_hpoon_records_v1(first_line string, scanner *bufio.Scanner) (HarpoonRecord, error) {
+	var last_marked MarkEntry
+	marks := make([]MarkEntry, 0)
+
+	add_line := func(line string) {
+		key, val, parse_err := parse_hpoon_line_v1(line)
+		if parse_err != nil {
+			report("Failed to hpoon marks file", parse_err.Error())
+			return
+		}
+		kind := KIND_FILE
+		if info, stat_err := os.Stat(val); stat_err == nil && info.IsDir() {
+			kind = KIND_DIR
+		}
+		entry := MarkEntry{name: key, path: val, kind: kind}
 		if key == LAST_MARKED_KEY {
-			last_marked = val
+			last_marked = entry
 		} else {
-			data[key] = val
+			marks = append(marks, entry)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		file.Close()
-		quit("Error parsing file:", err)
+	add_line(first_line)
+	for scanner.Scan() {
+		add_line(scanner.Text())
 	}
 
-	return HarpoonRecord{
-		last_marked: last_marked,
-		marks:       data,
+	if scan_err := scanner.Err(); scan_err != nil {
+		return HarpoonRecord{}, err("Error parsing file: %s", scan_err.Error())
 	}
+
+	return HarpoonRecord{last_marked: last_marked, marks: marks}, nil
 }
 
-func write_hpoon_file(data HarpoonRecord, filename string) {
-	file, err := os.Create(filename)
-	if err != nil {
-		quit("Error opening hpoon marks file '%s' reason: %s", filename, err.Error())
+// write_hpoon_file writes to a temp file in the same directory as filename
+// and renames it into place, so a reader never observes a partially
+// written marks file and a crash mid-write can't corrupt the existing one.
+// It always writes the v2 format, so reading a v1 file and writing it back
+// auto-migrates it. Like read_hpoon_file, it returns errors rather than
+// calling quit - it also runs with the marks file lock held.
+func write_hpoon_file(data HarpoonRecord, filename string) error {
+	tmp_file, create_err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".tmp-*")
+	if create_err != nil {
+		return err("Error opening hpoon marks file '%s' reason: %s", filename, create_err.Error())
 	}
-	defer file.Close()
+	tmp_name := tmp_file.Name()
+	defer os.Remove(tmp_name) // no-op once the rename below succeeds
 
 	non_value := func(v string) bool {
 		return v == ""
 	}
 
-	if non_value(data.last_marked) {
-		// we don't have data to write, so just return
-		return
+	var write_err error
+	write_line := func(line string) {
+		if write_err != nil {
+			return
+		}
+		_, write_err = tmp_file.WriteString(line + "\n")
 	}
 
-	last_marked_line := create_hpoon_line(LAST_MARKED_KEY, data.last_marked)
-
-	_, err = file.WriteString(last_marked_line + "\n")
+	// The header (and last_marked record) are written whenever there's
+	// anything to persist at all, not just when last_marked is set -
+	// named marks must survive even if last_marked is still empty.
+	has_last_marked := !non_value(data.last_marked.path)
+	if has_last_marked || len(data.marks) > 0 {
+		write_line(HPOON_V2_HEADER)
+		if has_last_marked {
+			last_marked := data.last_marked
+			last_marked.name = LAST_MARKED_KEY
+			write_line(create_mark_line_v2(last_marked))
+		}
 
-	check_err := func(line string) {
-		if err != nil {
-			quit("Error writing to hpoon file: '%s' reason: '%s'", line, err.Error())
+		for _, mark := range data.marks {
+			if non_value(mark.path) {
+				continue
+			}
+			write_line(create_mark_line_v2(mark))
 		}
 	}
 
-	check_err(last_marked_line)
+	if write_err != nil {
+		tmp_file.Close()
+		return err("Error writing to hpoon file: '%s' reason: '%s'", filename, write_err.Error())
+	}
+	if close_err := tmp_file.Close(); close_err != nil {
+		return err("Error writing to hpoon file: '%s' reason: '%s'", filename, close_err.Error())
+	}
+	if rename_err := os.Rename(tmp_name, filename); rename_err != nil {
+		return err("Error replacing hpoon marks file '%s': %s", filename, rename_err.Error())
+	}
+	return nil
+}
 
-	for key, value := range data.marks {
-		if non_value(value) {
-			continue
-		}
-		line := create_hpoon_line(key, value)
-		_, err = file.WriteString(line + "\n")
-		check_err(line)
+func load_cursor() int {
+	content, read_err := os.ReadFile(get_cursor_file())
+	if read_err != nil {
+		return 0
 	}
+	slot, parse_err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if parse_err != nil {
+		return 0
+	}
+	return slot
 }
 
-func load_hpoon() HarpoonRecord {
-	hpoon_file := get_hpoon_file()
+func save_cursor(slot int) {
+	os.WriteFile(get_cursor_file(), []byte(strconv.Itoa(slot)), 0644)
+}
+
+// load_hpoon_at/update_hpoon_at do their work and return any error instead
+// of calling quit, so a failure partway through is reported only after the
+// deferred lock.release() above has already run. quit()ing while still
+// holding the lock would os.Exit past that defer and, on Windows, leave
+// the exclusive-create ".lock" file behind forever (see lock_windows.go).
+func load_hpoon_at(hpoon_file string) (HarpoonRecord, error) {
+	lock, lock_err := acquire_hpoon_lock(hpoon_file, false)
+	if lock_err != nil {
+		return HarpoonRecord{}, err("Error locking hpoon marks file '%s': %s", hpoon_file, lock_err.Error())
+	}
+	defer lock.release()
+
 	if !check_path_exists(hpoon_file) {
 		os.Create(hpoon_file)
 	}
@@ -185,8 +539,46 @@ func load_hpoon() HarpoonRecord {
 	return read_hpoon_file(hpoon_file)
 }
 
-func save_hpoon(data HarpoonRecord) {
-	write_hpoon_file(data, get_hpoon_file())
+func load_hpoon() HarpoonRecord {
+	data, load_err := load_hpoon_at(get_hpoon_file())
+	if load_err != nil {
+		quit(load_err.Error())
+	}
+	return data
+}
+
+// update_hpoon_at runs mutate against the current marks under an exclusive
+// lock and writes the result back before releasing it, so the whole
+// read-modify-write cycle is atomic with respect to other hpoon processes.
+// mutate reports its own failures (e.g. "no such mark") by returning an
+// error instead of calling quit, for the same reason load_hpoon_at does.
+func update_hpoon_at(hpoon_file string, mutate func(data *HarpoonRecord) error) error {
+	lock, lock_err := acquire_hpoon_lock(hpoon_file, true)
+	if lock_err != nil {
+		return err("Error locking hpoon marks file '%s': %s", hpoon_file, lock_err.Error())
+	}
+	defer lock.release()
+
+	data := HarpoonRecord{marks: make([]MarkEntry, 0)}
+	if check_path_exists(hpoon_file) {
+		read_data, read_err := read_hpoon_file(hpoon_file)
+		if read_err != nil {
+			return read_err
+		}
+		data = read_data
+	}
+
+	if mutate_err := mutate(&data); mutate_err != nil {
+		return mutate_err
+	}
+
+	return write_hpoon_file(data, hpoon_file)
+}
+
+func update_hpoon(mutate func(data *HarpoonRecord) error) {
+	if update_err := update_hpoon_at(get_hpoon_file(), mutate); update_err != nil {
+		quit(update_err.Error())
+	}
 }
 
 func run_no_arg() {
@@ -197,7 +589,7 @@ func run_no_arg() {
 	}
 
 	// no arg given, print the last given harpooned file
-	fmt.Print(*mark)
+	fmt.Print(format_mark_output(*mark))
 }
 
 func check_path_exists(fpath string) bool {
@@ -205,31 +597,103 @@ func check_path_exists(fpath string) bool {
 	return !os.IsNotExist(err)
 }
 
-func hpoon_set_mark(fpath string, name *string) {
-	data := load_hpoon()
-	data.last_marked = fpath
-	if name != nil {
-		data.marks[*name] = fpath
+// hpoon_set_mark prefers a running daemon (see daemon.go) when HPOON_SOCKET
+// is set, falling back to direct, locked file access otherwise.
+func hpoon_set_mark(fpath string, name *string, line int) {
+	if daemon_available() && try_daemon_set_mark(fpath, name, line) {
+		return
 	}
-	save_hpoon(data)
+	hpoon_set_mark_local(fpath, name, line)
 }
 
-func hpoon_get_mark(name *string) (*string, error) {
-	data := load_hpoon()
+func hpoon_set_mark_local(fpath string, name *string, line int) {
+	if set_err := hpoon_set_mark_at(get_hpoon_file(), fpath, name, line); set_err != nil {
+		quit(set_err.Error())
+	}
+}
+
+// hpoon_set_mark_at is hpoon_set_mark_local against an explicit marks file
+// rather than one resolved from the current process's cwd/scope, so the
+// daemon (see daemon.go) can operate on the scope the *client* resolved
+// instead of its own.
+func hpoon_set_mark_at(hpoon_file string, fpath string, name *string, line int) error {
+	return update_hpoon_at(hpoon_file, func(data *HarpoonRecord) error {
+		data.last_marked = make_mark_entry(LAST_MARKED_KEY, fpath, line)
+		if name != nil {
+			entry := make_mark_entry(*name, fpath, line)
+			if idx, exists := find_mark(*data, *name); exists {
+				data.marks[idx] = entry
+			} else {
+				data.marks = append(data.marks, entry)
+			}
+		}
+		return nil
+	})
+}
+
+// hpoon_get_mark looks up a named mark (or the last-marked path if name is
+// nil), preferring a running daemon when HPOON_SOCKET is set. If the
+// recorded path no longer exists, it tries to relocate the mark under
+// HPOON_SEARCH_ROOT by content digest and, on success, persists the new
+// path so future lookups don't repeat the walk.
+func hpoon_get_mark(name *string) (*MarkEntry, error) {
+	if daemon_available() {
+		if mark, ok := try_daemon_get_mark(name); ok {
+			return mark, nil
+		}
+	}
+	return hpoon_get_mark_local(name)
+}
+
+func hpoon_get_mark_local(name *string) (*MarkEntry, error) {
+	return hpoon_get_mark_at(get_hpoon_file(), name)
+}
+
+// hpoon_get_mark_at is hpoon_get_mark_local against an explicit marks file;
+// see hpoon_set_mark_at.
+func hpoon_get_mark_at(hpoon_file string, name *string) (*MarkEntry, error) {
+	data, load_err := load_hpoon_at(hpoon_file)
+	if load_err != nil {
+		return nil, load_err
+	}
 	if name == nil {
 		return &data.last_marked, nil
 	}
-	value, exists := data.marks[*name]
+	idx, exists := find_mark(data, *name)
 	if !exists {
 		return nil, fmt.Errorf("mark '%s' does not exist", *name)
 	}
-	return &value, nil
+
+	entry := data.marks[idx]
+	if !check_path_exists(entry.path) {
+		if relocated, ok := try_relocate_mark(entry); ok {
+			entry = relocated
+			update_hpoon_at(hpoon_file, func(d *HarpoonRecord) error {
+				if i, found := find_mark(*d, *name); found {
+					d.marks[i] = entry
+				}
+				return nil
+			})
+		}
+	}
+	return &entry, nil
+}
+
+func hpoon_get_slot(data HarpoonRecord, slot int) (*MarkEntry, error) {
+	if slot < 1 || slot > len(data.marks) {
+		return nil, err("slot %d out of range (have %d marks)", slot, len(data.marks))
+	}
+	return &data.marks[slot-1], nil
 }
 
 func check_name_ref(arg string) bool {
 	return strings.HasPrefix(arg, NAME_REF)
 }
 
+func check_slot_ref(arg string) bool {
+	return strings.HasPrefix(arg, SLOT_REF)
+}
+
 func hpoon_out_mark_at(arg string) {
 	name := arg[len(NAME_REF):]
 	mark, err := hpoon_get_mark(&name)
@@ -237,44 +701,329 @@ func hpoon_out_mark_at(arg string) {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
-	fmt.Print(*mark)
+	fmt.Print(format_mark_output(*mark))
+}
+
+func hpoon_out_mark_at_slot(arg string) {
+	slot_str := arg[len(SLOT_REF):]
+	slot, conv_err := strconv.Atoi(slot_str)
+	if conv_err != nil {
+		quit("Invalid slot: '%s'", slot_str)
+	}
+
+	data := load_hpoon()
+	mark, err := hpoon_get_slot(data, slot)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	save_cursor(slot)
+	fmt.Print(format_mark_output(*mark))
+}
+
+// hpoon_cycle moves the current slot cursor by delta (wrapping around the
+// ends of the list) and prints the path now under it, mirroring
+// next/prev navigation in the harpoon nvim plugin.
+func hpoon_cycle(delta int) {
+	data := load_hpoon()
+	if len(data.marks) == 0 {
+		quit("No marks to cycle through")
+	}
+
+	count := len(data.marks)
+	cur := load_cursor()
+	slot := ((cur-1+delta)%count+count)%count + 1
+
+	save_cursor(slot)
+	fmt.Print(format_mark_output(data.marks[slot-1]))
+}
+
+// hpoon_reorder moves the mark "name" to slot, preferring a running daemon
+// when HPOON_SOCKET is set so subscribed clients are notified of the move.
+func hpoon_reorder(name string, slot_str string) {
+	slot, conv_err := strconv.Atoi(slot_str)
+	if conv_err != nil {
+		quit("Invalid slot: '%s'", slot_str)
+	}
+
+	if daemon_available() && try_daemon_reorder(name, slot) {
+		return
+	}
+	if reorder_err := hpoon_reorder_at(get_hpoon_file(), name, slot); reorder_err != nil {
+		quit(reorder_err.Error())
+	}
+}
+
+// hpoon_reorder_at is hpoon_reorder against an explicit marks file; see
+// hpoon_set_mark_at.
+func hpoon_reorder_at(hpoon_file string, name string, slot int) error {
+	return update_hpoon_at(hpoon_file, func(data *HarpoonRecord) error {
+		idx, exists := find_mark(*data, name)
+		if !exists {
+			return err("mark '%s' does not exist", name)
+		}
+		if slot < 1 || slot > len(data.marks) {
+			return err("slot %d out of range (have %d marks)", slot, len(data.marks))
+		}
+
+		entry := data.marks[idx]
+		data.marks = append(data.marks[:idx], data.marks[idx+1:]...)
+		insert_at := slot - 1
+		data.marks = append(data.marks[:insert_at], append([]MarkEntry{entry}, data.marks[insert_at:]...)...)
+		return nil
+	})
+}
+
+// jsonMark is the --json list shape; omitempty keeps marks migrated from a
+// v1 file (no line/created_at/source) from printing noisy zero values.
+type jsonMark struct {
+	Slot      int    `json:"slot"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Kind      string `json:"kind"`
+	Line      int    `json:"line,omitempty"`
+	CreatedAt int64  `json:"created_at,omitempty"`
+	Source    string `json:"source,omitempty"`
+}
+
+// hpoon_list_marks fetches the current marks in slot order, preferring a
+// running daemon when HPOON_SOCKET is set.
+func hpoon_list_marks() []MarkEntry {
+	if daemon_available() {
+		if marks, ok := try_daemon_list(); ok {
+			return marks
+		}
+	}
+	return load_hpoon().marks
+}
+
+func to_json_marks(marks []MarkEntry) []jsonMark {
+	out := make([]jsonMark, 0, len(marks))
+	for i, mark := range marks {
+		out = append(out, jsonMark{
+			Slot:      i + 1,
+			Name:      mark.name,
+			Path:      mark.path,
+			Kind:      mark.kind,
+			Line:      mark.line,
+			CreatedAt: mark.created_at,
+			Source:    mark.source,
+		})
+	}
+	return out
 }
 
 func hpoon_list() {
-	record := load_hpoon()
+	marks := hpoon_list_marks()
+
+	if json_output {
+		encoded, marshal_err := json.MarshalIndent(to_json_marks(marks), "", "  ")
+		if marshal_err != nil {
+			quit("Error encoding marks as JSON: %s", marshal_err.Error())
+		}
+		fmt.Println(string(encoded))
+		return
+	}
+
 	output := ""
-	for mark, path := range record.marks {
-		output = output + fmt.Sprintf("%s: %s\n", mark, path)
+	for i, mark := range marks {
+		output = output + fmt.Sprintf("%d: %s: %s\n", i+1, mark.name, format_mark_output(mark))
 	}
 	fmt.Print(output)
 }
 
+// hpoon_fzf prints marks one per line as "slot<TAB>name<TAB>path", the
+// shape fzf --with-nth / --preview expect, for binding a picker to e.g.
+// Ctrl-h without having to re-implement the listing in shell.
+func hpoon_fzf() {
+	for i, mark := range hpoon_list_marks() {
+		fmt.Printf("%d\t%s\t%s\n", i+1, mark.name, format_mark_output(mark))
+	}
+}
+
+// hpoon_clean removes the marks file, preferring a running daemon when
+// HPOON_SOCKET is set so subscribed clients are notified.
 func hpoon_clean() {
+	if daemon_available() && try_daemon_clean() {
+		return
+	}
 	os.Remove(get_hpoon_file())
 }
 
+// hpoon_delete removes a single named mark, preferring a running daemon
+// when HPOON_SOCKET is set.
+func hpoon_delete(name string) {
+	if daemon_available() && try_daemon_delete(name) {
+		return
+	}
+	if delete_err := hpoon_delete_local(name); delete_err != nil {
+		quit(delete_err.Error())
+	}
+}
+
+// hpoon_delete_local returns an error instead of calling quit so the
+// daemon (handle_daemon_delete) can report it to just the one connection
+// instead of exiting the whole process.
+func hpoon_delete_local(name string) error {
+	return hpoon_delete_at(get_hpoon_file(), name)
+}
+
+// hpoon_delete_at is hpoon_delete_local against an explicit marks file; see
+// hpoon_set_mark_at.
+func hpoon_delete_at(hpoon_file string, name string) error {
+	found := false
+	update_err := update_hpoon_at(hpoon_file, func(data *HarpoonRecord) error {
+		idx, exists := find_mark(*data, name)
+		if !exists {
+			return nil
+		}
+		found = true
+		data.marks = append(data.marks[:idx], data.marks[idx+1:]...)
+		return nil
+	})
+	if update_err != nil {
+		return update_err
+	}
+	if !found {
+		return fmt.Errorf("mark '%s' does not exist", name)
+	}
+	return nil
+}
+
+// verify_mark returns a human-readable problem with mark's target, or ""
+// if it still looks like what was originally marked.
+func verify_mark(mark MarkEntry) string {
+	if !check_path_exists(mark.path) {
+		return fmt.Sprintf("%s no longer exists", mark.path)
+	}
+	if mark.digest == "" {
+		return ""
+	}
+
+	current_digest, digest_err := compute_mark_digest(mark.path, mark.kind)
+	if digest_err != nil {
+		return fmt.Sprintf("could not verify %s: %s", mark.path, digest_err.Error())
+	}
+	if current_digest != mark.digest {
+		return fmt.Sprintf("%s has changed since marking", mark.path)
+	}
+	return ""
+}
+
+func hpoon_verify() {
+	data := load_hpoon()
+
+	found_issue := false
+	report_issue := func(name string, mark MarkEntry) {
+		if mark.path == "" {
+			return
+		}
+		if issue := verify_mark(mark); issue != "" {
+			found_issue = true
+			fmt.Printf("%s: %s\n", name, issue)
+		}
+	}
+
+	report_issue(LAST_MARKED_KEY, data.last_marked)
+	for _, mark := range data.marks {
+		report_issue(mark.name, mark)
+	}
+
+	if !found_issue {
+		fmt.Println("All marks verified OK")
+	}
+}
+
+// hpoon_expire drops marks last touched more than max_age_days ago, preferring
+// a running daemon when HPOON_SOCKET is set so subscribed clients are
+// notified. Marks migrated from a v1 file have created_at == 0 and are kept,
+// since "unknown age" shouldn't be treated as "infinitely stale".
+func hpoon_expire(max_age_days_str string) {
+	max_age_days, conv_err := strconv.Atoi(max_age_days_str)
+	if conv_err != nil {
+		quit("Invalid day count: '%s'", max_age_days_str)
+	}
+
+	if daemon_available() && try_daemon_expire(max_age_days) {
+		return
+	}
+	if expire_err := hpoon_expire_at(get_hpoon_file(), max_age_days); expire_err != nil {
+		quit(expire_err.Error())
+	}
+}
+
+// hpoon_expire_at is hpoon_expire against an explicit marks file; see
+// hpoon_set_mark_at.
+func hpoon_expire_at(hpoon_file string, max_age_days int) error {
+	cutoff := time.Now().Unix() - int64(max_age_days)*24*60*60
+
+	return update_hpoon_at(hpoon_file, func(data *HarpoonRecord) error {
+		kept := make([]MarkEntry, 0, len(data.marks))
+		for _, mark := range data.marks {
+			if mark.created_at == 0 || mark.created_at >= cutoff {
+				kept = append(kept, mark)
+			}
+		}
+		data.marks = kept
+		return nil
+	})
+}
+
+// parse_path_spec splits a trailing ":<line>" off arg, for the
+// "hpoon path:42" jump-to-line form. If there's no trailing ":<digits>",
+// arg is returned unchanged with line 0.
+func parse_path_spec(arg string) (string, int) {
+	idx := strings.LastIndex(arg, ":")
+	if idx == -1 {
+		return arg, 0
+	}
+	line, conv_err := strconv.Atoi(arg[idx+1:])
+	if conv_err != nil || line < 1 {
+		return arg, 0
+	}
+	return arg[:idx], line
+}
+
 func run_single_arg(arg string) {
 	switch arg {
 	case "-h", "--help":
 		fmt.Print(help_str)
 	case "clean":
 		hpoon_clean()
+	case "init":
+		hpoon_init()
 	case "list":
 		hpoon_list()
+	case "next":
+		hpoon_cycle(1)
+	case "prev":
+		hpoon_cycle(-1)
+	case "verify":
+		hpoon_verify()
+	case "daemon":
+		hpoon_daemon()
+	case "fzf":
+		hpoon_fzf()
 	default:
 		// we check if it's a name
 		if check_name_ref(arg) {
 			hpoon_out_mark_at(arg)
 			return
 		}
-		// we check if it's a path
-		path, err := filepath.Abs(arg)
+		// we check if it's a slot
+		if check_slot_ref(arg) {
+			hpoon_out_mark_at_slot(arg)
+			return
+		}
+		// we check if it's a path, optionally with a ":<line>" suffix
+		path_arg, line := parse_path_spec(arg)
+		path, err := filepath.Abs(path_arg)
 		if err != nil {
 			quit("Not sure what to do with: '%s'", arg)
 		}
 
 		if check_path_exists(path) {
-			hpoon_set_mark(path, nil)
+			hpoon_set_mark(path, nil, line)
 			return
 		}
 		// we abort, as we don't know what to do
@@ -283,28 +1032,72 @@ func run_single_arg(arg string) {
 }
 
 func run_double_arg(arg string, name string) {
-	// path, err := filepath.Abs(arg)
-	// if err != nil {
-	// 	quit("Not sure how to expand '%s'", arg)
-	// }
-	if !check_path_exists(arg) {
-		quit("Filepath doesn't exist: '%s'", arg)
-	}
-	if arg == "." {
+	if arg == "expire" {
+		hpoon_expire(name)
+		return
+	}
+	if arg == "delete" {
+		hpoon_delete(name)
+		return
+	}
+
+	path_arg, line := parse_path_spec(arg)
+	if !check_path_exists(path_arg) {
+		quit("Filepath doesn't exist: '%s'", path_arg)
+	}
+	if path_arg == "." {
 		cwd, _ := os.Getwd()
-		arg = cwd
+		path_arg = cwd
+	}
+	hpoon_set_mark(path_arg, &name, line)
+}
+
+func run_triple_arg(cmd string, arg1 string, arg2 string) {
+	switch cmd {
+	case "reorder":
+		hpoon_reorder(arg1, arg2)
+	default:
+		quit(short_help)
+	}
+}
+
+// extract_flags pulls --global/--local/--json out of args (in any
+// position) and returns the remaining args alongside the scope and JSON
+// settings they selected, so the positional dispatch below doesn't need to
+// know about flags at all.
+func extract_flags(args []string) ([]string, string, bool) {
+	scope := ""
+	json := false
+	remaining := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg {
+		case "--global":
+			scope = "global"
+		case "--local":
+			scope = "local"
+		case "--json":
+			json = true
+		default:
+			remaining = append(remaining, arg)
+		}
 	}
-	hpoon_set_mark(arg, &name)
+	return remaining, scope, json
 }
 
 func main() {
-	switch len(os.Args) {
-	case 1:
+	args, scope, json := extract_flags(os.Args[1:])
+	forced_scope = scope
+	json_output = json
+
+	switch len(args) {
+	case 0:
 		run_no_arg()
+	case 1:
+		run_single_arg(args[0])
 	case 2:
-		run_single_arg(os.Args[1])
+		run_double_arg(args[0], args[1])
 	case 3:
-		run_double_arg(os.Args[1], os.Args[2])
+		run_triple_arg(args[0], args[1], args[2])
 	default:
 		quit(short_help)
 	}
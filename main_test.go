@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestHpoonSetMarkConcurrent(t *testing.T) {
+	test_hpoon_file_override = filepath.Join(t.TempDir(), "hpoon")
+	defer func() { test_hpoon_file_override = "" }()
+
+	const n = 25
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("mark%d", i)
+			hpoon_set_mark(fmt.Sprintf("/tmp/path%d", i), &name, 0)
+		}(i)
+	}
+	wg.Wait()
+
+	data, read_err := read_hpoon_file(test_hpoon_file_override)
+	if read_err != nil {
+		t.Fatalf("read_hpoon_file: %s", read_err)
+	}
+	if len(data.marks) != n {
+		t.Fatalf("expected %d marks to survive, got %d", n, len(data.marks))
+	}
+
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("mark%d", i)
+		if _, exists := find_mark(data, name); !exists {
+			t.Errorf("mark %q missing from final file", name)
+		}
+	}
+}